@@ -0,0 +1,376 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// Component identifies one of the agent processes the installer can manage
+// independently, mirroring the CoreAgentContainerName/TraceAgentContainerName
+// style constants used elsewhere to name agent processes.
+type Component string
+
+const (
+	// ComponentCore is the main datadog-agent process.
+	ComponentCore Component = "core"
+	// ComponentTrace is the trace-agent (APM).
+	ComponentTrace Component = "trace"
+	// ComponentProcess is the process-agent.
+	ComponentProcess Component = "process"
+	// ComponentSystemProbe is system-probe.
+	ComponentSystemProbe Component = "system-probe"
+	// ComponentSecurity is the security-agent.
+	ComponentSecurity Component = "security"
+)
+
+// componentRegistration pairs a Component with the stable/experimental unit
+// pair it owns and any prerequisite setup it needs before its units can
+// start (e.g. mounting debugfs for system-probe).
+type componentRegistration struct {
+	component Component
+	stable    unit
+	exp       unit
+	// prerequisite runs once before the component's units are loaded.
+	// It may be nil.
+	prerequisite func(ctx context.Context, initSystem InitSystem) error
+}
+
+// componentRegistry is the single source of truth for which units belong to
+// which component. SetupAgentWithComponents, AddComponent, RemoveComponent,
+// and RemoveAgent all drive themselves from this instead of hard-coded
+// slices, so they stay in lockstep as components are added.
+var componentRegistry = []componentRegistration{
+	{
+		component: ComponentCore,
+		stable:    unit{name: agentComponent, variant: variantStable},
+		exp:       unit{name: agentComponent, variant: variantExperimental},
+	},
+	{
+		component: ComponentTrace,
+		stable:    unit{name: traceAgentComponent, variant: variantStable},
+		exp:       unit{name: traceAgentComponent, variant: variantExperimental},
+	},
+	{
+		component: ComponentProcess,
+		stable:    unit{name: processAgentComponent, variant: variantStable},
+		exp:       unit{name: processAgentComponent, variant: variantExperimental},
+	},
+	{
+		component:    ComponentSystemProbe,
+		stable:       unit{name: systemProbeComponent, variant: variantStable},
+		exp:          unit{name: systemProbeComponent, variant: variantExperimental},
+		prerequisite: ensureDebugfsMounted,
+	},
+	{
+		component:    ComponentSecurity,
+		stable:       unit{name: securityAgentComponent, variant: variantStable},
+		exp:          unit{name: securityAgentComponent, variant: variantExperimental},
+		prerequisite: ensureAuditdRunning,
+	},
+}
+
+// installedComponentsPath persists which components are actually present on
+// the host, so RemoveAgent and RemoveComponent only ever tear down units
+// that were installed in the first place. A var rather than a const so
+// tests can point it at a temp directory instead of the real host state.
+var installedComponentsPath = "/var/lib/datadog-installer/components.json"
+
+// allComponents returns every known component, preserving SetupAgent's
+// historical all-or-nothing behavior.
+func allComponents() []Component {
+	components := make([]Component, 0, len(componentRegistry))
+	for _, reg := range componentRegistry {
+		components = append(components, reg.component)
+	}
+	return components
+}
+
+func registrationFor(component Component) (componentRegistration, error) {
+	for _, reg := range componentRegistry {
+		if reg.component == component {
+			return reg, nil
+		}
+	}
+	return componentRegistration{}, fmt.Errorf("unknown component %q", component)
+}
+
+func loadInstalledComponents() ([]Component, error) {
+	content, err := os.ReadFile(installedComponentsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var components []Component
+	if err := json.Unmarshal(content, &components); err != nil {
+		return nil, err
+	}
+	return components, nil
+}
+
+// installedUnits returns the stable and experimental units for whichever
+// components are actually recorded as installed, falling back to every
+// known component when nothing is recorded (hosts installed before the
+// components registry existed). Anything that acts on units present on the
+// host — RemoveAgent, the experiment watchdog — should derive its unit
+// lists from this instead of the package-level stableUnits/experimentalUnits,
+// which simply enumerate every component the installer knows how to manage.
+func installedUnits() (stable []unit, experimental []unit, err error) {
+	components, err := loadInstalledComponents()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(components) == 0 {
+		components = allComponents()
+	}
+	return unitsForComponents(components)
+}
+
+func saveInstalledComponents(components []Component) error {
+	if err := os.MkdirAll(filepath.Dir(installedComponentsPath), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(components)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(installedComponentsPath, content, 0644)
+}
+
+func addInstalledComponent(component Component) error {
+	components, err := loadInstalledComponents()
+	if err != nil {
+		return err
+	}
+	for _, c := range components {
+		if c == component {
+			return nil
+		}
+	}
+	return saveInstalledComponents(append(components, component))
+}
+
+func removeInstalledComponent(component Component) error {
+	components, err := loadInstalledComponents()
+	if err != nil {
+		return err
+	}
+	filtered := components[:0]
+	for _, c := range components {
+		if c != component {
+			filtered = append(filtered, c)
+		}
+	}
+	return saveInstalledComponents(filtered)
+}
+
+// unitsForComponents renders component's stable and experimental unit pairs
+// into flat unit slices, in the order components are given.
+func unitsForComponents(components []Component) (stable []unit, experimental []unit, err error) {
+	for _, component := range components {
+		reg, err := registrationFor(component)
+		if err != nil {
+			return nil, nil, err
+		}
+		stable = append(stable, reg.stable)
+		experimental = append(experimental, reg.exp)
+	}
+	return stable, experimental, nil
+}
+
+// SetupAgentWithComponents installs and starts only the given components,
+// running each one's prerequisite setup first.
+func SetupAgentWithComponents(ctx context.Context, components []Component) error {
+	initSystem, err := detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, component := range components {
+		reg, err := registrationFor(component)
+		if err != nil {
+			return err
+		}
+		if reg.prerequisite != nil {
+			if err := reg.prerequisite(ctx, initSystem); err != nil {
+				return fmt.Errorf("prerequisite setup for %s failed: %w", component, err)
+			}
+		}
+	}
+
+	stable, experimental, err := unitsForComponents(components)
+	if err != nil {
+		return err
+	}
+
+	// setInstallerAgentGroup has no clean inverse: once dd-installer is
+	// added to the dd-agent group there's no dedicated helper to remove it
+	// again, so it's journaled with an empty inverse purely as a record.
+	if err = journaledStep("set_installer_agent_group", "", func() error {
+		return setInstallerAgentGroup(ctx)
+	}); err != nil {
+		return err
+	}
+
+	for _, u := range stable {
+		u := u
+		if err = journaledStep("load_unit:"+unitToken(u), "remove_unit:"+unitToken(u), func() error {
+			return initSystem.Load(ctx, u)
+		}); err != nil {
+			return err
+		}
+	}
+	for _, u := range experimental {
+		u := u
+		if err = journaledStep("load_unit:"+unitToken(u), "remove_unit:"+unitToken(u), func() error {
+			return initSystem.Load(ctx, u)
+		}); err != nil {
+			return err
+		}
+	}
+	if err = initSystem.Reload(ctx); err != nil {
+		return err
+	}
+	for _, u := range stable {
+		u := u
+		if err = journaledStep("enable_unit:"+unitToken(u), "disable_unit:"+unitToken(u), func() error {
+			return initSystem.Enable(ctx, u)
+		}); err != nil {
+			return err
+		}
+	}
+	for _, u := range stable {
+		u := u
+		if err = journaledStep("start_unit:"+unitToken(u), "stop_unit:"+unitToken(u), func() error {
+			return initSystem.Start(ctx, u)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return saveInstalledComponents(components)
+}
+
+// AddComponent installs and starts a single additional component on an
+// already set-up host.
+func AddComponent(ctx context.Context, component Component) error {
+	reg, err := registrationFor(component)
+	if err != nil {
+		return err
+	}
+	initSystem, err := detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	if reg.prerequisite != nil {
+		if err := reg.prerequisite(ctx, initSystem); err != nil {
+			return fmt.Errorf("prerequisite setup for %s failed: %w", component, err)
+		}
+	}
+
+	if err := journaledStep("load_unit:"+unitToken(reg.stable), "remove_unit:"+unitToken(reg.stable), func() error {
+		return initSystem.Load(ctx, reg.stable)
+	}); err != nil {
+		return err
+	}
+	if err := journaledStep("load_unit:"+unitToken(reg.exp), "remove_unit:"+unitToken(reg.exp), func() error {
+		return initSystem.Load(ctx, reg.exp)
+	}); err != nil {
+		return err
+	}
+	if err := initSystem.Reload(ctx); err != nil {
+		return err
+	}
+	if err := journaledStep("enable_unit:"+unitToken(reg.stable), "disable_unit:"+unitToken(reg.stable), func() error {
+		return initSystem.Enable(ctx, reg.stable)
+	}); err != nil {
+		return err
+	}
+	if err := journaledStep("start_unit:"+unitToken(reg.stable), "stop_unit:"+unitToken(reg.stable), func() error {
+		return initSystem.Start(ctx, reg.stable)
+	}); err != nil {
+		return err
+	}
+
+	return addInstalledComponent(component)
+}
+
+// RemoveComponent stops and removes a single component, leaving the rest of
+// the install untouched.
+func RemoveComponent(ctx context.Context, component Component) error {
+	reg, err := registrationFor(component)
+	if err != nil {
+		return err
+	}
+	initSystem, err := detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := initSystem.Stop(ctx, reg.exp); err != nil {
+		return fmt.Errorf("Failed to stop %s: %s", reg.exp.name, err)
+	}
+	if err := initSystem.Stop(ctx, reg.stable); err != nil {
+		return fmt.Errorf("Failed to stop %s: %s", reg.stable.name, err)
+	}
+	if err := initSystem.Disable(ctx, reg.exp); err != nil {
+		return fmt.Errorf("Failed to disable %s: %s", reg.exp.name, err)
+	}
+	if err := initSystem.Remove(ctx, reg.exp); err != nil {
+		return fmt.Errorf("Failed to remove %s: %s", reg.exp.name, err)
+	}
+	if err := initSystem.Disable(ctx, reg.stable); err != nil {
+		return fmt.Errorf("Failed to disable %s: %s", reg.stable.name, err)
+	}
+	if err := initSystem.Remove(ctx, reg.stable); err != nil {
+		return fmt.Errorf("Failed to remove %s: %s", reg.stable.name, err)
+	}
+
+	// Drop this component's steps from the setup journal so a later
+	// RemoveAgent doesn't try to undo units that are already gone.
+	if err := removeJournalEntriesForUnits([]unit{reg.stable, reg.exp}); err != nil {
+		return err
+	}
+
+	return removeInstalledComponent(component)
+}
+
+// ensureDebugfsMounted mounts debugfs if it isn't already, which
+// system-probe requires to read kernel tracing data.
+func ensureDebugfsMounted(ctx context.Context, _ InitSystem) error {
+	if pathExists("/sys/kernel/debug/tracing") {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, "mount", "-t", "debugfs", "debugfs", "/sys/kernel/debug").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount debugfs: %w: %s", err, out)
+	}
+	return nil
+}
+
+// ensureAuditdRunning starts auditd if it isn't already running, which
+// security-agent requires to receive audit events.
+func ensureAuditdRunning(ctx context.Context, initSystem InitSystem) error {
+	auditd := unit{name: "auditd", variant: variantStable}
+	active, err := initSystem.IsActive(ctx, auditd)
+	if err == nil && active {
+		return nil
+	}
+	log.Infof("Starting auditd for security-agent")
+	return initSystem.Start(ctx, auditd)
+}
@@ -0,0 +1,93 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withInstallerStateDir points installedComponentsPath and journalPath at a
+// temp directory for the duration of the test, so tests never touch the
+// real host's installer state.
+func withInstallerStateDir(t *testing.T) string {
+	dir := t.TempDir()
+
+	origComponents, origJournal := installedComponentsPath, journalPath
+	installedComponentsPath = filepath.Join(dir, "components.json")
+	journalPath = filepath.Join(dir, "setup.journal")
+	t.Cleanup(func() {
+		installedComponentsPath, journalPath = origComponents, origJournal
+	})
+
+	return dir
+}
+
+func TestInstalledUnitsFallsBackToAllComponentsWhenRegistryAbsent(t *testing.T) {
+	withInstallerStateDir(t)
+
+	stable, experimental, err := installedUnits()
+	require.NoError(t, err)
+
+	wantStable, wantExperimental, err := unitsForComponents(allComponents())
+	require.NoError(t, err)
+	assert.Equal(t, wantStable, stable)
+	assert.Equal(t, wantExperimental, experimental)
+}
+
+func TestInstalledUnitsUsesTheRecordedRegistry(t *testing.T) {
+	withInstallerStateDir(t)
+
+	require.NoError(t, saveInstalledComponents([]Component{ComponentCore, ComponentTrace}))
+
+	stable, experimental, err := installedUnits()
+	require.NoError(t, err)
+
+	wantStable, wantExperimental, err := unitsForComponents([]Component{ComponentCore, ComponentTrace})
+	require.NoError(t, err)
+	assert.Equal(t, wantStable, stable)
+	assert.Equal(t, wantExperimental, experimental)
+}
+
+func TestRemoveComponentPrunesOnlyItsOwnJournalEntries(t *testing.T) {
+	withInstallerStateDir(t)
+
+	coreReg, err := registrationFor(ComponentCore)
+	require.NoError(t, err)
+	traceReg, err := registrationFor(ComponentTrace)
+	require.NoError(t, err)
+
+	// Simulate the journal entries SetupAgentWithComponents would have left
+	// behind for two installed components.
+	require.NoError(t, appendJournalEntry(journalEntry{Kind: journalSessionStarted}))
+	require.NoError(t, appendJournalEntry(journalEntry{
+		Kind: journalStepStarted, Step: "load_unit:" + unitToken(coreReg.stable), Inverse: "remove_unit:" + unitToken(coreReg.stable),
+	}))
+	require.NoError(t, appendJournalEntry(journalEntry{Kind: journalStepCompleted, Step: "load_unit:" + unitToken(coreReg.stable)}))
+	require.NoError(t, appendJournalEntry(journalEntry{
+		Kind: journalStepStarted, Step: "load_unit:" + unitToken(traceReg.stable), Inverse: "remove_unit:" + unitToken(traceReg.stable),
+	}))
+	require.NoError(t, appendJournalEntry(journalEntry{Kind: journalStepCompleted, Step: "load_unit:" + unitToken(traceReg.stable)}))
+	require.NoError(t, appendJournalEntry(journalEntry{Kind: journalSessionDone}))
+
+	require.NoError(t, removeJournalEntriesForUnits([]unit{traceReg.stable, traceReg.exp}))
+
+	entries, err := readJournal()
+	require.NoError(t, err)
+
+	var steps []string
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Step, traceReg.stable.name)
+		assert.NotContains(t, entry.Inverse, traceReg.stable.name)
+		steps = append(steps, entry.Step)
+	}
+	assert.Contains(t, steps, "load_unit:"+unitToken(coreReg.stable))
+}
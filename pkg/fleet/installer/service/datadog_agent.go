@@ -20,39 +20,44 @@ import (
 )
 
 const (
-	agentUnit         = "datadog-agent.service"
-	traceAgentUnit    = "datadog-agent-trace.service"
-	processAgentUnit  = "datadog-agent-process.service"
-	systemProbeUnit   = "datadog-agent-sysprobe.service"
-	securityAgentUnit = "datadog-agent-security.service"
-	agentExp          = "datadog-agent-exp.service"
-	traceAgentExp     = "datadog-agent-trace-exp.service"
-	processAgentExp   = "datadog-agent-process-exp.service"
-	systemProbeExp    = "datadog-agent-sysprobe-exp.service"
-	securityAgentExp  = "datadog-agent-security-exp.service"
+	agentComponent         = "datadog-agent"
+	traceAgentComponent    = "datadog-agent-trace"
+	processAgentComponent  = "datadog-agent-process"
+	systemProbeComponent   = "datadog-agent-sysprobe"
+	securityAgentComponent = "datadog-agent-security"
 )
 
-var (
-	stableUnits = []string{
-		agentUnit,
-		traceAgentUnit,
-		processAgentUnit,
-		systemProbeUnit,
-		securityAgentUnit,
-	}
-	experimentalUnits = []string{
-		agentExp,
-		traceAgentExp,
-		processAgentExp,
-		systemProbeExp,
-		securityAgentExp,
-	}
-)
+// stableUnits and experimentalUnits are the full set of units the installer
+// knows about, in componentRegistry order. Anything that needs to act on
+// units present on the host rather than units the installer merely knows
+// about should go through loadInstalledComponents instead.
+var stableUnits, experimentalUnits = func() ([]unit, []unit) {
+	stable, experimental, err := unitsForComponents(allComponents())
+	if err != nil {
+		// allComponents() is always drawn from componentRegistry itself, so
+		// this can only happen if the registry is inconsistent with itself.
+		panic(err)
+	}
+	return stable, experimental
+}()
+
+// allUnits returns every unit the installer manages, stable and
+// experimental alike.
+func allUnits() []unit {
+	units := make([]unit, 0, len(stableUnits)+len(experimentalUnits))
+	units = append(units, stableUnits...)
+	units = append(units, experimentalUnits...)
+	return units
+}
 
-// SetupAgent installs and starts the agent
+// SetupAgent installs and starts every known component. It is a thin
+// wrapper around SetupAgentWithComponents for hosts that want the
+// historical all-or-nothing behavior.
 func SetupAgent(ctx context.Context) (err error) {
 	span, ctx := tracer.StartSpanFromContext(ctx, "setup_agent")
+	var initSystem InitSystem
 	defer func() {
+		logStartupDiagnostics(ctx, span, "setup_agent", initSystem, allUnits())
 		if err != nil {
 			log.Errorf("Failed to setup agent: %s, reverting", err)
 			err = RemoveAgent(ctx)
@@ -63,97 +68,111 @@ func SetupAgent(ctx context.Context) (err error) {
 		span.Finish(tracer.WithError(err))
 	}()
 
-	if err = setInstallerAgentGroup(ctx); err != nil {
-		return
-	}
-
-	for _, unit := range stableUnits {
-		if err = loadUnit(ctx, unit); err != nil {
-			return
-		}
-	}
-	for _, unit := range experimentalUnits {
-		if err = loadUnit(ctx, unit); err != nil {
-			return
-		}
+	initSystem, err = detectInitSystem(ctx)
+	if err != nil {
+		return err
 	}
+	log.Infof("Using %s as the init system", initSystem.Name())
 
-	if err = systemdReload(ctx); err != nil {
-		return
+	if err = appendJournalEntry(journalEntry{Kind: journalSessionStarted}); err != nil {
+		return err
 	}
 
-	for _, unit := range stableUnits {
-		if err = enableUnit(ctx, unit); err != nil {
-			return
-		}
-	}
-	for _, unit := range stableUnits {
-		if err = startUnit(ctx, unit); err != nil {
-			return
-		}
+	if err = SetupAgentWithComponents(ctx, allComponents()); err != nil {
+		return err
 	}
-	if err = createAgentSymlink(ctx); err != nil {
-		return
+	if err = journaledStep("create_symlink", "remove_symlink", func() error {
+		return createAgentSymlink(ctx)
+	}); err != nil {
+		return err
 	}
 
 	// write installinfo before start, or the agent could write it
 	// TODO: add installer version properly
-	if err = installinfo.WriteInstallInfo("installer_package", "manual_update"); err != nil {
-		return
+	if err = journaledStep("write_install_info", "rm_install_info", func() error {
+		return installinfo.WriteInstallInfo("installer_package", "manual_update")
+	}); err != nil {
+		return err
 	}
 
-	return
+	return appendJournalEntry(journalEntry{Kind: journalSessionDone})
 }
 
-// RemoveAgent stops and removes the agent
+// RemoveAgent stops and removes whatever the setup journal says is
+// installed, by replaying each recorded step's inverse action. This is what
+// makes partial installs clean up correctly: a step that never finished
+// (or was never reached) simply has no entry to replay. Hosts with an empty
+// journal (installed before the journal existed, or left clean by a prior
+// RemoveAgent) fall back to the installed-components registry, and
+// ultimately to tearing down every known component, matching RemoveAgent's
+// historical behavior.
 func RemoveAgent(ctx context.Context) error {
 	span, ctx := tracer.StartSpanFromContext(ctx, "remove_agent_units")
 	defer span.Finish()
+
+	initSystem, err := detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	stable, experimental, err := installedUnits()
+	if err != nil {
+		return err
+	}
+	units := make([]unit, 0, len(stable)+len(experimental))
+	units = append(units, stable...)
+	units = append(units, experimental...)
+	defer logStartupDiagnostics(ctx, span, "remove_agent", initSystem, units)
+
+	entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		if err := replayInverses(ctx, initSystem, entries); err != nil {
+			return err
+		}
+		if err := clearJournal(); err != nil {
+			return err
+		}
+		return saveInstalledComponents(nil)
+	}
+
 	// stop experiments, they can restart stable agent
-	for _, unit := range experimentalUnits {
-		if err := stopUnit(ctx, unit); err != nil {
-			return fmt.Errorf("Failed to stop %s: %s", unit, err)
+	for _, u := range experimental {
+		if err := initSystem.Stop(ctx, u); err != nil {
+			return fmt.Errorf("Failed to stop %s: %s", u.name, err)
 		}
 	}
 	// stop stable agents
-	for _, unit := range stableUnits {
-		if err := stopUnit(ctx, unit); err != nil {
-			return fmt.Errorf("Failed to stop %s: %s", unit, err)
+	for _, u := range stable {
+		if err := initSystem.Stop(ctx, u); err != nil {
+			return fmt.Errorf("Failed to stop %s: %s", u.name, err)
 		}
 	}
 	// purge experimental units
-	for _, unit := range experimentalUnits {
-		if err := disableUnit(ctx, unit); err != nil {
-			return fmt.Errorf("Failed to disable %s: %s", unit, err)
+	for _, u := range experimental {
+		if err := initSystem.Disable(ctx, u); err != nil {
+			return fmt.Errorf("Failed to disable %s: %s", u.name, err)
 		}
-		if err := removeUnit(ctx, unit); err != nil {
-			return fmt.Errorf("Failed to remove %s: %s", unit, err)
+		if err := initSystem.Remove(ctx, u); err != nil {
+			return fmt.Errorf("Failed to remove %s: %s", u.name, err)
 		}
 	}
 	// purge stable units
-	for _, unit := range stableUnits {
-		if err := disableUnit(ctx, unit); err != nil {
-			return fmt.Errorf("Failed to disable %s: %s", unit, err)
+	for _, u := range stable {
+		if err := initSystem.Disable(ctx, u); err != nil {
+			return fmt.Errorf("Failed to disable %s: %s", u.name, err)
 		}
-		if err := removeUnit(ctx, unit); err != nil {
-			return fmt.Errorf("Failed to remove %s: %s", unit, err)
+		if err := initSystem.Remove(ctx, u); err != nil {
+			return fmt.Errorf("Failed to remove %s: %s", u.name, err)
 		}
 	}
 	if err := rmAgentSymlink(ctx); err != nil {
 		return fmt.Errorf("Failed to remove agent symlink: %s", err)
 	}
 	installinfo.RmInstallInfo()
-	return nil
-}
-
-// StartAgentExperiment starts the agent experiment
-func StartAgentExperiment(ctx context.Context) error {
-	return startUnit(ctx, agentExp)
-}
-
-// StopAgentExperiment stops the agent experiment
-func StopAgentExperiment(ctx context.Context) error {
-	return startUnit(ctx, agentUnit)
+	return saveInstalledComponents(nil)
 }
 
 // setInstallerAgentGroup adds the dd-installer to the dd-agent group if it's not already in it
@@ -0,0 +1,303 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// defaultExperimentWindow is how long the watchdog supervises an
+	// experiment before rolling it back if it hasn't been promoted.
+	defaultExperimentWindow = 15 * time.Minute
+	// watchdogPollInterval is how often the watchdog checks unit and agent
+	// health while an experiment is running.
+	watchdogPollInterval = 5 * time.Second
+	// maxConsecutiveUnhealthyPolls is how many consecutive failed polls the
+	// watchdog tolerates before rolling the experiment back.
+	maxConsecutiveUnhealthyPolls = 3
+	// agentBinaryPath is the stable agent binary used to probe experiment
+	// health through its local health-check entry point.
+	agentBinaryPath = "/opt/datadog-agent/bin/agent/agent"
+)
+
+// ExperimentOptions configures the watchdog supervising a running
+// experiment.
+type ExperimentOptions struct {
+	// Window is how long to supervise the experiment before automatically
+	// rolling it back if PromoteExperiment hasn't been called. Zero means
+	// defaultExperimentWindow.
+	Window time.Duration
+}
+
+// watchdogState is the lifecycle state of the experiment watchdog, exposed
+// through ExperimentWatchdogStatus so remote-config-driven upgrades can
+// react to it.
+type watchdogState string
+
+const (
+	watchdogStateIdle       watchdogState = "idle"
+	watchdogStateRunning    watchdogState = "running"
+	watchdogStatePromoted   watchdogState = "promoted"
+	watchdogStateRolledBack watchdogState = "rolled-back"
+)
+
+// WatchdogStatus reports the current state of the experiment watchdog.
+type WatchdogStatus struct {
+	State     watchdogState
+	Reason    string
+	StartedAt time.Time
+}
+
+var (
+	watchdogMu     sync.Mutex
+	watchdogStatus = WatchdogStatus{State: watchdogStateIdle}
+	watchdogCancel context.CancelFunc
+)
+
+// ExperimentWatchdogStatus returns the current state of the experiment
+// watchdog: whether an experiment is running, was promoted, or was rolled
+// back, and why.
+func ExperimentWatchdogStatus() WatchdogStatus {
+	watchdogMu.Lock()
+	defer watchdogMu.Unlock()
+	return watchdogStatus
+}
+
+func setWatchdogStatus(state watchdogState, reason string) {
+	watchdogMu.Lock()
+	defer watchdogMu.Unlock()
+	watchdogStatus = WatchdogStatus{State: state, Reason: reason, StartedAt: watchdogStatus.StartedAt}
+	if state == watchdogStateRunning {
+		watchdogStatus.StartedAt = time.Now()
+	}
+}
+
+// StartAgentExperiment starts the agent experiment units and launches a
+// watchdog goroutine that supervises them for opts.Window, rolling back to
+// the stable units once maxConsecutiveUnhealthyPolls health checks in a row
+// have failed, or when the window elapses without a promotion.
+func StartAgentExperiment(ctx context.Context, opts ExperimentOptions) (err error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "start_agent_experiment")
+	var initSystem InitSystem
+	var experimental []unit
+	defer func() {
+		logStartupDiagnostics(ctx, span, "start_agent_experiment", initSystem, experimental)
+		span.Finish(tracer.WithError(err))
+	}()
+
+	initSystem, err = detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+
+	stable, experimental, err := installedUnits()
+	if err != nil {
+		return err
+	}
+
+	started := make([]unit, 0, len(experimental))
+	for _, u := range experimental {
+		if err = initSystem.Start(ctx, u); err != nil {
+			// Roll back whatever we already started rather than leaving a
+			// partial experiment running with no watchdog armed to catch it.
+			if rollbackErr := rollbackExperiment(ctx, initSystem, stable, started, "partial start failure"); rollbackErr != nil {
+				log.Warnf("Failed to roll back a partial experiment start: %s", rollbackErr)
+			}
+			return fmt.Errorf("Failed to start %s: %s", u.name, err)
+		}
+		started = append(started, u)
+	}
+
+	window := opts.Window
+	if window <= 0 {
+		window = defaultExperimentWindow
+	}
+
+	watchdogCtx, cancel := context.WithCancel(context.Background())
+	watchdogMu.Lock()
+	if watchdogCancel != nil {
+		watchdogCancel()
+	}
+	watchdogCancel = cancel
+	watchdogMu.Unlock()
+	setWatchdogStatus(watchdogStateRunning, "")
+
+	go superviseExperiment(watchdogCtx, initSystem, stable, experimental, window)
+
+	return nil
+}
+
+// StopAgentExperiment stops the agent experiment and restarts the stable
+// units, mirroring what the watchdog does on an automatic rollback.
+func StopAgentExperiment(ctx context.Context) (err error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "stop_agent_experiment")
+	var initSystem InitSystem
+	var experimental []unit
+	defer func() {
+		logStartupDiagnostics(ctx, span, "stop_agent_experiment", initSystem, experimental)
+		span.Finish(tracer.WithError(err))
+	}()
+
+	initSystem, err = detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+	stable, experimental, err := installedUnits()
+	if err != nil {
+		return err
+	}
+	cancelWatchdog()
+	return rollbackExperiment(ctx, initSystem, stable, experimental, "stopped by operator")
+}
+
+// PromoteExperiment swaps the experiment into the stable slot: it stops and
+// disables the experimental units, leaving the stable units (whose
+// binary/config the package manager already updated to the promoted
+// version before this is called) running on their own. It refuses to
+// promote an experiment that isn't currently healthy. The swap is not
+// atomic: a unit that fails to stop, disable, or start is not undone for
+// the others, the first error is returned, and the watchdog status is then
+// left as it was.
+func PromoteExperiment(ctx context.Context) error {
+	initSystem, err := detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+	stable, experimental, err := installedUnits()
+	if err != nil {
+		return err
+	}
+
+	if err := checkExperimentHealth(ctx, initSystem, experimental); err != nil {
+		return fmt.Errorf("refusing to promote unhealthy experiment: %w", err)
+	}
+
+	cancelWatchdog()
+
+	if err := swapToStable(ctx, initSystem, stable, experimental); err != nil {
+		return err
+	}
+
+	setWatchdogStatus(watchdogStatePromoted, "")
+	return nil
+}
+
+// cancelWatchdog stops any running supervisor goroutine without performing
+// the rollback itself; callers that want a rollback call rollbackExperiment
+// separately.
+func cancelWatchdog() {
+	watchdogMu.Lock()
+	defer watchdogMu.Unlock()
+	if watchdogCancel != nil {
+		watchdogCancel()
+		watchdogCancel = nil
+	}
+}
+
+// superviseExperiment polls the experimental units and the agent health
+// endpoint until window elapses or a failure is observed, rolling the
+// experiment back in either case.
+func superviseExperiment(ctx context.Context, initSystem InitSystem, stable, experimental []unit, window time.Duration) {
+	deadline := time.Now().Add(window)
+	ticker := time.NewTicker(watchdogPollInterval)
+	defer ticker.Stop()
+
+	unhealthyStreak := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Now().After(deadline) {
+			log.Warnf("Experiment watchdog: promotion window elapsed without PromoteExperiment, rolling back")
+			_ = rollbackExperiment(context.Background(), initSystem, stable, experimental, "promotion window expired")
+			return
+		}
+
+		if err := checkExperimentHealth(ctx, initSystem, experimental); err != nil {
+			unhealthyStreak++
+			log.Warnf("Experiment watchdog: health check failed (%d/%d): %s", unhealthyStreak, maxConsecutiveUnhealthyPolls, err)
+		} else {
+			unhealthyStreak = 0
+		}
+
+		if unhealthyStreak >= maxConsecutiveUnhealthyPolls {
+			reason := fmt.Sprintf("experiment unhealthy %d consecutive checks", unhealthyStreak)
+			log.Warnf("Experiment watchdog: %s, rolling back", reason)
+			_ = rollbackExperiment(context.Background(), initSystem, stable, experimental, reason)
+			return
+		}
+	}
+}
+
+// checkExperimentHealth reports an error if any experimental unit is not
+// active, or if the agent's own health check fails.
+func checkExperimentHealth(ctx context.Context, initSystem InitSystem, experimental []unit) error {
+	for _, u := range experimental {
+		active, err := initSystem.IsActive(ctx, u)
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", u.name, err)
+		}
+		if !active {
+			return fmt.Errorf("%s is not active", u.name)
+		}
+	}
+	return checkAgentHealth(ctx)
+}
+
+// checkAgentHealth probes the running agent's health through its local
+// command-line entry point, which talks to the agent over its IPC socket.
+func checkAgentHealth(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, agentBinaryPath, "health").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("agent health check failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// rollbackExperiment stops and disables the experimental units and restarts
+// the stable ones, recording why in the watchdog status.
+func rollbackExperiment(ctx context.Context, initSystem InitSystem, stable, experimental []unit, reason string) error {
+	err := swapToStable(ctx, initSystem, stable, experimental)
+	setWatchdogStatus(watchdogStateRolledBack, reason)
+	return err
+}
+
+// swapToStable stops and disables the experimental units and starts the
+// stable ones, collecting the first error but trying every unit regardless:
+// a host left with some experimental units still running and the stable
+// ones never started is worse than one with a single unit left in whatever
+// state it failed in. Shared by rollbackExperiment and PromoteExperiment,
+// the two places that swap the experiment out of the running set.
+func swapToStable(ctx context.Context, initSystem InitSystem, stable, experimental []unit) error {
+	var firstErr error
+	for _, u := range experimental {
+		if err := initSystem.Stop(ctx, u); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Failed to stop %s: %w", u.name, err)
+		}
+		if err := initSystem.Disable(ctx, u); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Failed to disable %s: %w", u.name, err)
+		}
+	}
+	for _, u := range stable {
+		if err := initSystem.Start(ctx, u); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Failed to start %s: %w", u.name, err)
+		}
+	}
+	return firstErr
+}
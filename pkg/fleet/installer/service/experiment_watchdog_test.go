@@ -0,0 +1,89 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchdogStateTransitions(t *testing.T) {
+	setWatchdogStatus(watchdogStateIdle, "")
+
+	setWatchdogStatus(watchdogStateRunning, "")
+	running := ExperimentWatchdogStatus()
+	assert.Equal(t, watchdogStateRunning, running.State)
+	assert.False(t, running.StartedAt.IsZero())
+
+	setWatchdogStatus(watchdogStatePromoted, "")
+	promoted := ExperimentWatchdogStatus()
+	assert.Equal(t, watchdogStatePromoted, promoted.State)
+	// Promotion doesn't restart the supervision window.
+	assert.Equal(t, running.StartedAt, promoted.StartedAt)
+
+	setWatchdogStatus(watchdogStateRolledBack, "promotion window expired")
+	rolledBack := ExperimentWatchdogStatus()
+	assert.Equal(t, watchdogStateRolledBack, rolledBack.State)
+	assert.Equal(t, "promotion window expired", rolledBack.Reason)
+}
+
+func TestRollbackExperimentStopsDisablesThenStartsStable(t *testing.T) {
+	setWatchdogStatus(watchdogStateRunning, "")
+	fake := newFakeInitSystem()
+	stable := []unit{{name: "datadog-agent", variant: variantStable}}
+	experimental := []unit{{name: "datadog-agent", variant: variantExperimental}}
+
+	err := rollbackExperiment(context.Background(), fake, stable, experimental, "unhealthy")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"stop:experimental/datadog-agent",
+		"disable:experimental/datadog-agent",
+		"start:stable/datadog-agent",
+	}, fake.calls)
+
+	status := ExperimentWatchdogStatus()
+	assert.Equal(t, watchdogStateRolledBack, status.State)
+	assert.Equal(t, "unhealthy", status.Reason)
+}
+
+func TestRollbackExperimentReturnsFirstError(t *testing.T) {
+	fake := newFakeInitSystem()
+	fake.stopErr = assert.AnError
+	stable := []unit{{name: "datadog-agent", variant: variantStable}}
+	experimental := []unit{{name: "datadog-agent", variant: variantExperimental}}
+
+	err := rollbackExperiment(context.Background(), fake, stable, experimental, "unhealthy")
+	assert.ErrorIs(t, err, assert.AnError)
+	// The stable unit is still started even though stopping the
+	// experimental one failed: a host left with neither running is worse.
+	assert.Contains(t, fake.calls, "start:stable/datadog-agent")
+}
+
+func TestCheckExperimentHealthReportsInactiveUnit(t *testing.T) {
+	fake := newFakeInitSystem()
+	experimental := []unit{{name: "datadog-agent", variant: variantExperimental}}
+
+	err := checkExperimentHealth(context.Background(), fake, experimental)
+	assert.Error(t, err)
+}
+
+func TestCheckExperimentHealthPassesActiveUnitsThroughToAgentCheck(t *testing.T) {
+	fake := newFakeInitSystem()
+	experimental := []unit{{name: "datadog-agent", variant: variantExperimental}}
+	fake.active[unitToken(experimental[0])] = true
+
+	// With every unit active, checkExperimentHealth falls through to
+	// checkAgentHealth, which shells out to the real agent binary and will
+	// fail in a test environment; we only assert it got that far rather
+	// than stopping on the unit-activity check.
+	err := checkExperimentHealth(context.Background(), fake, experimental)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "agent health check failed")
+}
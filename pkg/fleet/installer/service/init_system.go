@@ -0,0 +1,115 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// initSystemOverrideEnv lets operators force a specific init system backend
+// instead of relying on runtime detection, e.g. for containers or chroots
+// where the usual probes don't reflect the host that will actually run the
+// units.
+const initSystemOverrideEnv = "DD_INSTALLER_INIT_SYSTEM"
+
+// unitVariant distinguishes the stable unit of a component from its
+// experimental (canary) counterpart.
+type unitVariant string
+
+const (
+	variantStable       unitVariant = "stable"
+	variantExperimental unitVariant = "experimental"
+)
+
+// unit is an abstract descriptor for a service managed by the installer. It
+// carries no knowledge of any particular init system; each InitSystem
+// implementation renders it into the native unit/service definition it
+// manages (a systemd unit file, an OpenRC init script, a launchd plist, ...).
+type unit struct {
+	// name is the component's base name, e.g. "datadog-agent" or
+	// "datadog-agent-trace", without any init-system-specific suffix.
+	name string
+	// variant selects the stable or experimental (-exp) copy of the unit.
+	variant unitVariant
+}
+
+// InitSystem abstracts over the host's service manager so the installer can
+// load, enable, start, stop, disable, and remove agent units without caring
+// whether the host runs systemd, OpenRC, sysvinit, or launchd.
+type InitSystem interface {
+	// Name returns a short identifier for the backend, used in logs and
+	// diagnostics (e.g. "systemd", "openrc").
+	Name() string
+	// Load installs the native unit definition for u, without starting or
+	// enabling it.
+	Load(ctx context.Context, u unit) error
+	// Enable marks u to start automatically at boot.
+	Enable(ctx context.Context, u unit) error
+	// Start starts u immediately.
+	Start(ctx context.Context, u unit) error
+	// Stop stops u if it is running.
+	Stop(ctx context.Context, u unit) error
+	// Disable removes u from the boot-time startup sequence.
+	Disable(ctx context.Context, u unit) error
+	// Remove deletes the native unit definition for u. Load, Enable, or
+	// Start must not be called again for u afterwards.
+	Remove(ctx context.Context, u unit) error
+	// Reload refreshes the backend's view of installed units, analogous to
+	// `systemctl daemon-reload`. Backends without an equivalent step treat
+	// this as a no-op.
+	Reload(ctx context.Context) error
+	// IsActive reports whether u is currently running.
+	IsActive(ctx context.Context, u unit) (bool, error)
+}
+
+// detectInitSystem selects the InitSystem backend to use on the current
+// host. DD_INSTALLER_INIT_SYSTEM overrides detection when set. Otherwise the
+// host is probed in order of specificity: systemd, OpenRC, launchd, falling
+// back to sysvinit, which is assumed to be available wherever none of the
+// others are detected.
+func detectInitSystem(ctx context.Context) (InitSystem, error) {
+	if override := os.Getenv(initSystemOverrideEnv); override != "" {
+		return initSystemByName(override)
+	}
+
+	if pathExists("/run/systemd/system") {
+		return newSystemdInitSystem(), nil
+	}
+	if pathExists("/sbin/openrc") || pathExists("/sbin/openrc-run") {
+		return newOpenRCInitSystem(), nil
+	}
+	if pathExists("/bin/launchctl") || pathExists("/usr/bin/launchctl") {
+		return newLaunchdInitSystem(), nil
+	}
+	return newSysvinitInitSystem(ctx)
+}
+
+// initSystemByName resolves an explicit DD_INSTALLER_INIT_SYSTEM value to a
+// backend, bypassing detection entirely.
+func initSystemByName(name string) (InitSystem, error) {
+	switch name {
+	case "systemd":
+		return newSystemdInitSystem(), nil
+	case "openrc":
+		return newOpenRCInitSystem(), nil
+	case "sysvinit":
+		return newSysvinitInitSystem(context.Background())
+	case "launchd":
+		return newLaunchdInitSystem(), nil
+	default:
+		return nil, fmt.Errorf("unknown init system %q set via %s", name, initSystemOverrideEnv)
+	}
+}
+
+// pathExists reports whether path exists on the filesystem.
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import "context"
+
+// fakeInitSystem is an in-memory InitSystem used by tests that need to
+// observe what the package calls on a backend without shelling out to a
+// real service manager. Every call is recorded as "<method>:<variant>/<name>"
+// in call order; the *Err fields let tests make a specific method fail.
+type fakeInitSystem struct {
+	calls []string
+
+	loadErr    error
+	enableErr  error
+	startErr   error
+	stopErr    error
+	disableErr error
+	removeErr  error
+	reloadErr  error
+
+	active map[string]bool
+}
+
+func newFakeInitSystem() *fakeInitSystem {
+	return &fakeInitSystem{active: map[string]bool{}}
+}
+
+func (f *fakeInitSystem) Name() string { return "fake" }
+
+func (f *fakeInitSystem) Load(_ context.Context, u unit) error {
+	f.calls = append(f.calls, "load:"+unitToken(u))
+	return f.loadErr
+}
+
+func (f *fakeInitSystem) Enable(_ context.Context, u unit) error {
+	f.calls = append(f.calls, "enable:"+unitToken(u))
+	return f.enableErr
+}
+
+func (f *fakeInitSystem) Start(_ context.Context, u unit) error {
+	f.calls = append(f.calls, "start:"+unitToken(u))
+	if f.startErr != nil {
+		return f.startErr
+	}
+	f.active[unitToken(u)] = true
+	return nil
+}
+
+func (f *fakeInitSystem) Stop(_ context.Context, u unit) error {
+	f.calls = append(f.calls, "stop:"+unitToken(u))
+	if f.stopErr != nil {
+		return f.stopErr
+	}
+	f.active[unitToken(u)] = false
+	return nil
+}
+
+func (f *fakeInitSystem) Disable(_ context.Context, u unit) error {
+	f.calls = append(f.calls, "disable:"+unitToken(u))
+	return f.disableErr
+}
+
+func (f *fakeInitSystem) Remove(_ context.Context, u unit) error {
+	f.calls = append(f.calls, "remove:"+unitToken(u))
+	return f.removeErr
+}
+
+func (f *fakeInitSystem) Reload(_ context.Context) error {
+	f.calls = append(f.calls, "reload")
+	return f.reloadErr
+}
+
+func (f *fakeInitSystem) IsActive(_ context.Context, u unit) (bool, error) {
+	f.calls = append(f.calls, "is_active:"+unitToken(u))
+	return f.active[unitToken(u)], nil
+}
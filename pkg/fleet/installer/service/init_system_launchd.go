@@ -0,0 +1,112 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// launchdPlistDir is where launchd system daemons are installed on macOS.
+const launchdPlistDir = "/Library/LaunchDaemons"
+
+// launchdInitSystem is the InitSystem backend for macOS hosts. Units are
+// rendered to reverse-DNS launchd labels and plist files managed through
+// launchctl.
+type launchdInitSystem struct{}
+
+func newLaunchdInitSystem() InitSystem {
+	return &launchdInitSystem{}
+}
+
+func (l *launchdInitSystem) Name() string {
+	return "launchd"
+}
+
+// label renders u to the launchd service label it manages, e.g.
+// "com.datadoghq.datadog-agent" or "com.datadoghq.datadog-agent-trace-exp".
+func (l *launchdInitSystem) label(u unit) string {
+	label := "com.datadoghq." + u.name
+	if u.variant == variantExperimental {
+		label += "-exp"
+	}
+	return label
+}
+
+func (l *launchdInitSystem) plistPath(u unit) string {
+	return fmt.Sprintf("%s/%s.plist", launchdPlistDir, l.label(u))
+}
+
+// Load is a no-op: the plist is installed by the package at the path
+// launchctl expects, and bootstrap below is what actually registers it.
+func (l *launchdInitSystem) Load(_ context.Context, _ unit) error {
+	return nil
+}
+
+func (l *launchdInitSystem) bootstrap(ctx context.Context, u unit) error {
+	out, err := exec.CommandContext(ctx, "launchctl", "bootstrap", "system", l.plistPath(u)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl bootstrap %s: %w: %s", l.label(u), err, out)
+	}
+	return nil
+}
+
+// Enable registers the daemon with launchd; launchd has no separate
+// enable/start distinction, so Enable bootstraps it without also starting
+// it immediately (RunAtLoad in the plist governs start-at-boot behavior).
+func (l *launchdInitSystem) Enable(ctx context.Context, u unit) error {
+	return l.bootstrap(ctx, u)
+}
+
+func (l *launchdInitSystem) Start(ctx context.Context, u unit) error {
+	out, err := exec.CommandContext(ctx, "launchctl", "kickstart", "-k", "system/"+l.label(u)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl kickstart %s: %w: %s", l.label(u), err, out)
+	}
+	return nil
+}
+
+func (l *launchdInitSystem) Stop(ctx context.Context, u unit) error {
+	out, err := exec.CommandContext(ctx, "launchctl", "kill", "SIGTERM", "system/"+l.label(u)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl kill %s: %w: %s", l.label(u), err, out)
+	}
+	return nil
+}
+
+func (l *launchdInitSystem) Disable(ctx context.Context, u unit) error {
+	out, err := exec.CommandContext(ctx, "launchctl", "bootout", "system/"+l.label(u)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl bootout %s: %w: %s", l.label(u), err, out)
+	}
+	return nil
+}
+
+// Remove is a no-op: the plist file itself is owned by the package.
+func (l *launchdInitSystem) Remove(_ context.Context, _ unit) error {
+	return nil
+}
+
+// Reload has no launchd equivalent; bootstrap/bootout always act on the
+// current on-disk plist.
+func (l *launchdInitSystem) Reload(_ context.Context) error {
+	return nil
+}
+
+func (l *launchdInitSystem) IsActive(ctx context.Context, u unit) (bool, error) {
+	err := exec.CommandContext(ctx, "launchctl", "print", "system/"+l.label(u)).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		_ = exitErr
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
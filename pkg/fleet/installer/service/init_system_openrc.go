@@ -0,0 +1,101 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// openRCInitSystem is the InitSystem backend for OpenRC hosts (Alpine,
+// Gentoo). Units are rendered to init scripts under /etc/init.d and started
+// in the "default" runlevel via rc-update/rc-service.
+type openRCInitSystem struct{}
+
+func newOpenRCInitSystem() InitSystem {
+	return &openRCInitSystem{}
+}
+
+func (o *openRCInitSystem) Name() string {
+	return "openrc"
+}
+
+// serviceName renders u to the OpenRC init script name it manages.
+func (o *openRCInitSystem) serviceName(u unit) string {
+	if u.variant == variantExperimental {
+		return u.name + "-exp"
+	}
+	return u.name
+}
+
+func (o *openRCInitSystem) runRCService(ctx context.Context, u unit, action string) error {
+	name := o.serviceName(u)
+	out, err := exec.CommandContext(ctx, "rc-service", name, action).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rc-service %s %s: %w: %s", name, action, err, out)
+	}
+	return nil
+}
+
+// Load is a no-op on OpenRC: the init script ships with the package and is
+// not generated or templated by the installer.
+func (o *openRCInitSystem) Load(_ context.Context, _ unit) error {
+	return nil
+}
+
+func (o *openRCInitSystem) Enable(ctx context.Context, u unit) error {
+	name := o.serviceName(u)
+	out, err := exec.CommandContext(ctx, "rc-update", "add", name, "default").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rc-update add %s default: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+func (o *openRCInitSystem) Start(ctx context.Context, u unit) error {
+	return o.runRCService(ctx, u, "start")
+}
+
+func (o *openRCInitSystem) Stop(ctx context.Context, u unit) error {
+	return o.runRCService(ctx, u, "stop")
+}
+
+func (o *openRCInitSystem) Disable(ctx context.Context, u unit) error {
+	name := o.serviceName(u)
+	out, err := exec.CommandContext(ctx, "rc-update", "del", name, "default").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rc-update del %s default: %w: %s", name, err, out)
+	}
+	return nil
+}
+
+// Remove is a no-op on OpenRC, mirroring Load: the init script is owned by
+// the package, not generated by the installer.
+func (o *openRCInitSystem) Remove(_ context.Context, _ unit) error {
+	return nil
+}
+
+// Reload has no OpenRC equivalent of `systemctl daemon-reload`; rc-update
+// and rc-service always act on the current on-disk state.
+func (o *openRCInitSystem) Reload(_ context.Context) error {
+	return nil
+}
+
+func (o *openRCInitSystem) IsActive(ctx context.Context, u unit) (bool, error) {
+	name := o.serviceName(u)
+	err := exec.CommandContext(ctx, "rc-service", name, "status").Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		_ = exitErr
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,76 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"os/exec"
+)
+
+// systemdInitSystem is the InitSystem backend for hosts running systemd. It
+// renders units to their native ".service"/"-exp.service" names and defers
+// the actual systemctl invocations to the existing unit-level helpers.
+type systemdInitSystem struct{}
+
+func newSystemdInitSystem() InitSystem {
+	return &systemdInitSystem{}
+}
+
+func (s *systemdInitSystem) Name() string {
+	return "systemd"
+}
+
+// serviceName renders u to the systemd unit name it manages.
+func (s *systemdInitSystem) serviceName(u unit) string {
+	if u.variant == variantExperimental {
+		return u.name + "-exp.service"
+	}
+	return u.name + ".service"
+}
+
+func (s *systemdInitSystem) Load(ctx context.Context, u unit) error {
+	return loadUnit(ctx, s.serviceName(u))
+}
+
+func (s *systemdInitSystem) Enable(ctx context.Context, u unit) error {
+	return enableUnit(ctx, s.serviceName(u))
+}
+
+func (s *systemdInitSystem) Start(ctx context.Context, u unit) error {
+	return startUnit(ctx, s.serviceName(u))
+}
+
+func (s *systemdInitSystem) Stop(ctx context.Context, u unit) error {
+	return stopUnit(ctx, s.serviceName(u))
+}
+
+func (s *systemdInitSystem) Disable(ctx context.Context, u unit) error {
+	return disableUnit(ctx, s.serviceName(u))
+}
+
+func (s *systemdInitSystem) Remove(ctx context.Context, u unit) error {
+	return removeUnit(ctx, s.serviceName(u))
+}
+
+func (s *systemdInitSystem) Reload(ctx context.Context) error {
+	return systemdReload(ctx)
+}
+
+func (s *systemdInitSystem) IsActive(ctx context.Context, u unit) (bool, error) {
+	// `systemctl is-active` exits non-zero for every state but "active", so
+	// we only treat the command's own execution failure as an error.
+	err := exec.CommandContext(ctx, "systemctl", "is-active", "--quiet", s.serviceName(u)).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		_ = exitErr
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// sysvinitInitSystem is the InitSystem backend for plain sysvinit hosts
+// (older RHEL/Debian derivatives without systemd or OpenRC). Units are
+// rendered to /etc/init.d scripts, driven through the `service` command, and
+// enabled for boot via chkconfig or update-rc.d depending on which is
+// available.
+type sysvinitInitSystem struct {
+	// enableCmd is "chkconfig" or "update-rc.d", detected once at backend
+	// construction time.
+	enableCmd string
+}
+
+func newSysvinitInitSystem(_ context.Context) (InitSystem, error) {
+	for _, candidate := range []string{"chkconfig", "update-rc.d"} {
+		if path, err := exec.LookPath(candidate); err == nil && path != "" {
+			return &sysvinitInitSystem{enableCmd: candidate}, nil
+		}
+	}
+	return nil, fmt.Errorf("sysvinit detected but neither chkconfig nor update-rc.d is available")
+}
+
+func (s *sysvinitInitSystem) Name() string {
+	return "sysvinit"
+}
+
+// serviceName renders u to the /etc/init.d script name it manages.
+func (s *sysvinitInitSystem) serviceName(u unit) string {
+	if u.variant == variantExperimental {
+		return u.name + "-exp"
+	}
+	return u.name
+}
+
+func (s *sysvinitInitSystem) runService(ctx context.Context, u unit, action string) error {
+	name := s.serviceName(u)
+	out, err := exec.CommandContext(ctx, "service", name, action).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("service %s %s: %w: %s", name, action, err, out)
+	}
+	return nil
+}
+
+// Load is a no-op on sysvinit: the /etc/init.d script ships with the
+// package rather than being generated by the installer.
+func (s *sysvinitInitSystem) Load(_ context.Context, _ unit) error {
+	return nil
+}
+
+func (s *sysvinitInitSystem) Enable(ctx context.Context, u unit) error {
+	name := s.serviceName(u)
+	var cmd *exec.Cmd
+	if s.enableCmd == "chkconfig" {
+		cmd = exec.CommandContext(ctx, "chkconfig", name, "on")
+	} else {
+		cmd = exec.CommandContext(ctx, "update-rc.d", name, "defaults")
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", s.enableCmd, name, err, out)
+	}
+	return nil
+}
+
+func (s *sysvinitInitSystem) Start(ctx context.Context, u unit) error {
+	return s.runService(ctx, u, "start")
+}
+
+func (s *sysvinitInitSystem) Stop(ctx context.Context, u unit) error {
+	return s.runService(ctx, u, "stop")
+}
+
+func (s *sysvinitInitSystem) Disable(ctx context.Context, u unit) error {
+	name := s.serviceName(u)
+	var cmd *exec.Cmd
+	if s.enableCmd == "chkconfig" {
+		cmd = exec.CommandContext(ctx, "chkconfig", name, "off")
+	} else {
+		cmd = exec.CommandContext(ctx, "update-rc.d", "-f", name, "remove")
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", s.enableCmd, name, err, out)
+	}
+	return nil
+}
+
+// Remove is a no-op on sysvinit, mirroring Load.
+func (s *sysvinitInitSystem) Remove(_ context.Context, _ unit) error {
+	return nil
+}
+
+// Reload has no sysvinit equivalent; init.d scripts always act on current
+// on-disk state.
+func (s *sysvinitInitSystem) Reload(_ context.Context) error {
+	return nil
+}
+
+func (s *sysvinitInitSystem) IsActive(ctx context.Context, u unit) (bool, error) {
+	// `service <name> status` exits non-zero for every state but running, so
+	// we only treat the command's own execution failure (not found, denied,
+	// ...) as an error, matching the other backends. Run it directly rather
+	// than through runService, which wraps every error the same way and
+	// would lose that distinction.
+	err := exec.CommandContext(ctx, "service", s.serviceName(u), "status").Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		_ = exitErr
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,321 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/installinfo"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// journalPath is an append-only record of every mutating step SetupAgent
+// takes, so a crash partway through setup can be recovered from instead of
+// leaving the host half-installed. A var rather than a const so tests can
+// point it at a temp directory instead of the real host state.
+var journalPath = "/var/lib/datadog-installer/setup.journal"
+
+type journalEntryKind string
+
+const (
+	journalSessionStarted journalEntryKind = "session_started"
+	journalStepStarted    journalEntryKind = "step_started"
+	journalStepCompleted  journalEntryKind = "step_completed"
+	journalSessionDone    journalEntryKind = "session_completed"
+)
+
+// journalEntry is one line of the setup journal. Every mutating step is
+// logged before it runs (journalStepStarted) and again once it returns
+// successfully (journalStepCompleted), each paired with the inverse action
+// that undoes it so RemoveAgent and Recover can replay the journal
+// backwards instead of relying on hard-coded teardown logic.
+type journalEntry struct {
+	Timestamp string           `json:"timestamp"`
+	Kind      journalEntryKind `json:"kind"`
+	Step      string           `json:"step,omitempty"`
+	Inverse   string           `json:"inverse,omitempty"`
+}
+
+// journaledStep appends a journalStepStarted record for step/inverse, runs
+// fn, and appends journalStepCompleted if fn succeeds. If fn fails or the
+// process dies mid-step, the journal is left with a dangling "started"
+// record that a later Recover or RemoveAgent call uses to know the step
+// still needs undoing.
+func journaledStep(step, inverse string, fn func() error) error {
+	if err := appendJournalEntry(journalEntry{Kind: journalStepStarted, Step: step, Inverse: inverse}); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return appendJournalEntry(journalEntry{Kind: journalStepCompleted, Step: step})
+}
+
+func appendJournalEntry(entry journalEntry) error {
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(payload, '\n'))
+	return err
+}
+
+func readJournal() ([]journalEntry, error) {
+	content, err := os.ReadFile(journalPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("corrupt setup journal: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// clearJournal removes the journal once its steps have been fully replayed
+// and the host is back to a clean state, stable or empty.
+func clearJournal() error {
+	err := os.Remove(journalPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeJournal overwrites the journal with exactly entries, or removes it
+// if entries is empty. Used to prune steps for a component that has been
+// individually removed via RemoveComponent, so a later RemoveAgent doesn't
+// try to undo a unit that is already gone.
+//
+// It writes to a temp file in the same directory and renames it into place
+// rather than truncating journalPath directly, so a crash mid-write can
+// never leave readJournal looking at a half-written, unparsable file.
+func writeJournal(entries []journalEntry) error {
+	if len(entries) == 0 {
+		return clearJournal()
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(payload)
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(journalPath), "setup.journal.*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), journalPath)
+}
+
+// removeJournalEntriesForUnits prunes every journal record whose step or
+// inverse refers to one of units, in either direction.
+func removeJournalEntriesForUnits(units []unit) error {
+	entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tokens := make(map[string]bool, len(units))
+	for _, u := range units {
+		tokens[unitToken(u)] = true
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if journalEntryMentionsTokens(entry, tokens) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return writeJournal(kept)
+}
+
+func journalEntryMentionsTokens(entry journalEntry, tokens map[string]bool) bool {
+	for _, field := range []string{entry.Step, entry.Inverse} {
+		_, param, found := strings.Cut(field, ":")
+		if found && tokens[param] {
+			return true
+		}
+	}
+	return false
+}
+
+// replayInverses runs the inverse of every step in entries, latest first,
+// skipping steps with no inverse recorded. It collects the first error but
+// keeps going, since a host half torn-down is worse than one that's fully
+// torn down except for one stubborn step.
+func replayInverses(ctx context.Context, initSystem InitSystem, entries []journalEntry) error {
+	var firstErr error
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Kind != journalStepStarted || entry.Inverse == "" {
+			continue
+		}
+		if err := runInverseAction(ctx, initSystem, entry.Inverse); err != nil {
+			log.Warnf("Failed to undo %s (%s): %s", entry.Step, entry.Inverse, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// runInverseAction dispatches a single inverse action recorded in the
+// journal, e.g. "remove_unit:stable/datadog-agent".
+func runInverseAction(ctx context.Context, initSystem InitSystem, inverse string) error {
+	action, param, _ := strings.Cut(inverse, ":")
+	switch action {
+	case "remove_unit":
+		u, err := parseUnitToken(param)
+		if err != nil {
+			return err
+		}
+		return initSystem.Remove(ctx, u)
+	case "disable_unit":
+		u, err := parseUnitToken(param)
+		if err != nil {
+			return err
+		}
+		return initSystem.Disable(ctx, u)
+	case "stop_unit":
+		u, err := parseUnitToken(param)
+		if err != nil {
+			return err
+		}
+		return initSystem.Stop(ctx, u)
+	case "remove_symlink":
+		return rmAgentSymlink(ctx)
+	case "rm_install_info":
+		installinfo.RmInstallInfo()
+		return nil
+	default:
+		return fmt.Errorf("unknown inverse action %q", action)
+	}
+}
+
+// unitToken and parseUnitToken round-trip a unit through the journal as
+// "<variant>/<name>", e.g. "experimental/datadog-agent-trace".
+func unitToken(u unit) string {
+	return string(u.variant) + "/" + u.name
+}
+
+func parseUnitToken(token string) (unit, error) {
+	variant, name, found := strings.Cut(token, "/")
+	if !found {
+		return unit{}, fmt.Errorf("malformed unit token %q in setup journal", token)
+	}
+	return unit{name: name, variant: unitVariant(variant)}, nil
+}
+
+// pendingJournalEntries returns whatever was recorded after the last entry
+// that closes out a session one way or another: either the start of one
+// (session_started) or the clean end of one (session_completed). Anything
+// after it is dangling — either a session that never reached
+// session_completed, or steps journaled by an entry point like
+// AddComponent/RemoveComponent that doesn't bracket itself with its own
+// session markers at all. Either way those steps were never confirmed to
+// have finished and must be rolled back.
+func pendingJournalEntries(entries []journalEntry) []journalEntry {
+	lastMarker := -1
+	for i, entry := range entries {
+		if entry.Kind == journalSessionStarted || entry.Kind == journalSessionDone {
+			lastMarker = i
+		}
+	}
+	return entries[lastMarker+1:]
+}
+
+// Recover replays the setup journal at installer startup. If the last
+// SetupAgent call never reached its session_completed record, the process
+// died partway through setup; Recover undoes every step that session took,
+// leaving the host as if setup had never been attempted, rather than trying
+// to resume from wherever it stopped (not every mutating step here is safe
+// to repeat blindly, so rolling back is the conservative default). A
+// journal that ended cleanly is left untouched.
+//
+// Callers must invoke Recover once, before any other call into this
+// package, on every installer process start (daemon boot and one-shot CLI
+// invocations alike) so a journal left dangling by a crash is rolled back
+// before anything else touches the units it references. This package has
+// no process entrypoint of its own; the installer's main package is
+// responsible for wiring the call in.
+func Recover(ctx context.Context) error {
+	entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	pending := pendingJournalEntries(entries)
+	if len(pending) == 0 {
+		// The journal ends on a marker with nothing dangling after it:
+		// either a session completed cleanly, or one just started and
+		// hasn't journaled a step yet.
+		return nil
+	}
+
+	log.Warnf("Found an incomplete installer setup journal, rolling back")
+	initSystem, err := detectInitSystem(ctx)
+	if err != nil {
+		return err
+	}
+	if err := replayInverses(ctx, initSystem, pending); err != nil {
+		return fmt.Errorf("failed to roll back incomplete setup: %w", err)
+	}
+	return clearJournal()
+}
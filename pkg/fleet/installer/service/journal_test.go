@@ -0,0 +1,110 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitTokenRoundTrip(t *testing.T) {
+	units := []unit{
+		{name: "datadog-agent", variant: variantStable},
+		{name: "datadog-agent-trace", variant: variantExperimental},
+		{name: "datadog-agent-sysprobe", variant: variantStable},
+	}
+	for _, u := range units {
+		parsed, err := parseUnitToken(unitToken(u))
+		require.NoError(t, err)
+		assert.Equal(t, u, parsed)
+	}
+}
+
+func TestParseUnitTokenMalformed(t *testing.T) {
+	_, err := parseUnitToken("datadog-agent")
+	assert.Error(t, err)
+}
+
+func TestReplayInversesOrder(t *testing.T) {
+	fake := newFakeInitSystem()
+	entries := []journalEntry{
+		{Kind: journalStepStarted, Step: "load_unit:stable/a", Inverse: "remove_unit:stable/a"},
+		{Kind: journalStepCompleted, Step: "load_unit:stable/a"},
+		{Kind: journalStepStarted, Step: "enable_unit:stable/a", Inverse: "disable_unit:stable/a"},
+		// No step_completed: this step died mid-flight and still needs undoing.
+		{Kind: journalStepStarted, Step: "start_unit:stable/a", Inverse: "stop_unit:stable/a"},
+	}
+
+	err := replayInverses(context.Background(), fake, entries)
+	require.NoError(t, err)
+
+	// Inverses replay latest-started first, so the agent is stopped before
+	// it's disabled before its unit definition is removed.
+	assert.Equal(t, []string{"stop:stable/a", "disable:stable/a", "remove:stable/a"}, fake.calls)
+}
+
+func TestReplayInversesSkipsEmptyInverse(t *testing.T) {
+	fake := newFakeInitSystem()
+	entries := []journalEntry{
+		{Kind: journalStepStarted, Step: "set_installer_agent_group", Inverse: ""},
+	}
+
+	err := replayInverses(context.Background(), fake, entries)
+	require.NoError(t, err)
+	assert.Empty(t, fake.calls)
+}
+
+func TestPendingJournalEntriesStopsAtLastSessionCompleted(t *testing.T) {
+	entries := []journalEntry{
+		{Kind: journalSessionStarted},
+		{Kind: journalStepStarted, Step: "load_unit:stable/a", Inverse: "remove_unit:stable/a"},
+		{Kind: journalStepCompleted, Step: "load_unit:stable/a"},
+		{Kind: journalSessionDone},
+	}
+	assert.Empty(t, pendingJournalEntries(entries))
+}
+
+func TestPendingJournalEntriesFindsStepsJournaledOutsideASession(t *testing.T) {
+	// A clean SetupAgent session, followed by a later AddComponent call
+	// that journals a step without ever opening a new session_started —
+	// and crashes before its step_completed.
+	dangling := journalEntry{Kind: journalStepStarted, Step: "load_unit:stable/b", Inverse: "remove_unit:stable/b"}
+	entries := []journalEntry{
+		{Kind: journalSessionStarted},
+		{Kind: journalStepStarted, Step: "load_unit:stable/a", Inverse: "remove_unit:stable/a"},
+		{Kind: journalStepCompleted, Step: "load_unit:stable/a"},
+		{Kind: journalSessionDone},
+		dangling,
+	}
+	assert.Equal(t, []journalEntry{dangling}, pendingJournalEntries(entries))
+}
+
+func TestPendingJournalEntriesIncompleteSession(t *testing.T) {
+	step := journalEntry{Kind: journalStepStarted, Step: "load_unit:stable/a", Inverse: "remove_unit:stable/a"}
+	entries := []journalEntry{
+		{Kind: journalSessionStarted},
+		step,
+	}
+	assert.Equal(t, []journalEntry{step}, pendingJournalEntries(entries))
+}
+
+func TestReplayInversesCollectsFirstErrorButKeepsGoing(t *testing.T) {
+	fake := newFakeInitSystem()
+	fake.stopErr = assert.AnError
+	entries := []journalEntry{
+		{Kind: journalStepStarted, Step: "start_unit:stable/a", Inverse: "stop_unit:stable/a"},
+		{Kind: journalStepStarted, Step: "start_unit:stable/b", Inverse: "stop_unit:stable/b"},
+	}
+
+	err := replayInverses(context.Background(), fake, entries)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, []string{"stop:stable/b", "stop:stable/a"}, fake.calls)
+}
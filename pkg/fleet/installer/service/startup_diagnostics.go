@@ -0,0 +1,189 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// installInfoPath is where installinfo.WriteInstallInfo leaves a record of
+// an existing agent installation.
+const installInfoPath = "/etc/datadog-agent/install_info"
+
+// startupDiagnostics is a single structured snapshot of the host and the
+// action the installer just took, modeled after the startup log dd-trace-go
+// emits for the tracer: one JSON line that lets support triage a host from
+// `journalctl` output alone, without reconstructing state from installinfo,
+// `id -Gn`, and scattered log.Errorf calls.
+type startupDiagnostics struct {
+	Timestamp         string   `json:"timestamp"`
+	Event             string   `json:"event"`
+	OS                string   `json:"os"`
+	OSVersion         string   `json:"os_version"`
+	Kernel            string   `json:"kernel"`
+	Arch              string   `json:"arch"`
+	InitSystem        string   `json:"init_system"`
+	InitSystemVersion string   `json:"init_system_version,omitempty"`
+	InstallerVersion  string   `json:"installer_version"`
+	StableUnits       []string `json:"stable_units,omitempty"`
+	ExperimentalUnits []string `json:"experimental_units,omitempty"`
+	DDAgentGroups     []string `json:"dd_agent_groups,omitempty"`
+	DDInstallerGroups []string `json:"dd_installer_groups,omitempty"`
+	SELinuxMode       string   `json:"selinux_mode"`
+	AppArmorMode      string   `json:"apparmor_mode"`
+	CgroupVersion     string   `json:"cgroup_version"`
+	ExistingInstall   bool     `json:"existing_install"`
+}
+
+// logStartupDiagnostics builds a startupDiagnostics snapshot for event,
+// emits it as a single structured JSON log line, and sets it as a tag on
+// span so it shows up alongside the setup_agent/remove_agent_units traces.
+func logStartupDiagnostics(ctx context.Context, span tracer.Span, event string, initSystem InitSystem, units []unit) {
+	diagnostics := collectStartupDiagnostics(ctx, event, initSystem, units)
+	payload, err := json.Marshal(diagnostics)
+	if err != nil {
+		log.Warnf("Failed to marshal startup diagnostics: %s", err)
+		return
+	}
+	log.Infof("%s", payload)
+	if span != nil {
+		span.SetTag("startup_diagnostics", string(payload))
+	}
+}
+
+func collectStartupDiagnostics(ctx context.Context, event string, initSystem InitSystem, units []unit) *startupDiagnostics {
+	osName, osVersion := detectOSRelease()
+
+	initSystemName := "unknown"
+	initSystemVersion := ""
+	if initSystem != nil {
+		initSystemName = initSystem.Name()
+		initSystemVersion = detectInitSystemVersion(ctx, initSystemName)
+	}
+
+	var stableNames, expNames []string
+	for _, u := range units {
+		if u.variant == variantExperimental {
+			expNames = append(expNames, u.name)
+		} else {
+			stableNames = append(stableNames, u.name)
+		}
+	}
+
+	return &startupDiagnostics{
+		Timestamp:         time.Now().UTC().Format(time.RFC3339),
+		Event:             event,
+		OS:                osName,
+		OSVersion:         osVersion,
+		Kernel:            detectKernelVersion(ctx),
+		Arch:              runtime.GOARCH,
+		InitSystem:        initSystemName,
+		InitSystemVersion: initSystemVersion,
+		// TODO: add installer version properly, same gap noted in SetupAgent
+		InstallerVersion:  "unknown",
+		StableUnits:       stableNames,
+		ExperimentalUnits: expNames,
+		DDAgentGroups:     groupMembership(ctx, "dd-agent"),
+		DDInstallerGroups: groupMembership(ctx, "dd-installer"),
+		SELinuxMode:       detectSELinuxMode(ctx),
+		AppArmorMode:      detectAppArmorMode(),
+		CgroupVersion:     detectCgroupVersion(),
+		ExistingInstall:   pathExists(installInfoPath),
+	}
+}
+
+// detectOSRelease reads NAME and VERSION_ID out of /etc/os-release, falling
+// back to the Go runtime's OS name when the file isn't present (e.g. macOS).
+func detectOSRelease() (name string, version string) {
+	name = runtime.GOOS
+	content, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return name, version
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch key {
+		case "NAME":
+			name = value
+		case "VERSION_ID":
+			version = value
+		}
+	}
+	return name, version
+}
+
+func detectKernelVersion(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "uname", "-r").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// detectInitSystemVersion best-effort shells out to each backend's own
+// version flag; a failure here is diagnostic-only and never fails setup.
+func detectInitSystemVersion(ctx context.Context, initSystemName string) string {
+	var cmd *exec.Cmd
+	switch initSystemName {
+	case "systemd":
+		cmd = exec.CommandContext(ctx, "systemctl", "--version")
+	case "openrc":
+		cmd = exec.CommandContext(ctx, "openrc", "--version")
+	default:
+		return ""
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	firstLine, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(firstLine)
+}
+
+func groupMembership(ctx context.Context, user string) []string {
+	out, err := exec.CommandContext(ctx, "id", "-Gn", user).Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(out))
+}
+
+func detectSELinuxMode(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "getenforce").Output()
+	if err != nil {
+		return "disabled"
+	}
+	return strings.ToLower(strings.TrimSpace(string(out)))
+}
+
+func detectAppArmorMode() string {
+	if pathExists("/sys/kernel/security/apparmor") {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func detectCgroupVersion() string {
+	if pathExists("/sys/fs/cgroup/cgroup.controllers") {
+		return "v2"
+	}
+	return "v1"
+}